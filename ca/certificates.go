@@ -0,0 +1,171 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/initca"
+	cfsigner "github.com/cloudflare/cfssl/signer"
+)
+
+const (
+	// RootKeyAlgo is the key algorithm used for the root CA and node keys.
+	RootKeyAlgo = "ecdsa"
+	// RootKeySize is the key size, in bits, used for the root CA and node keys.
+	RootKeySize = 256
+	// RootCAExpiration is how long a freshly generated root CA certificate
+	// is valid for.
+	RootCAExpiration = "87600h"
+	// DefaultNodeCertExpiration is how long node certificates signed by
+	// ParseValidateAndSignCSR are valid for.
+	DefaultNodeCertExpiration = "2160h"
+)
+
+// signingPolicy returns the cfssl signing policy applied to every CSR signed
+// by the local root signer.
+func signingPolicy() *config.Signing {
+	expiry, err := time.ParseDuration(DefaultNodeCertExpiration)
+	if err != nil {
+		// DefaultNodeCertExpiration is a package constant; a parse failure
+		// here means the constant itself is malformed.
+		panic(fmt.Sprintf("invalid DefaultNodeCertExpiration: %v", err))
+	}
+
+	return &config.Signing{
+		Default: &config.SigningProfile{
+			Usage:        []string{"signing", "key encipherment", "server auth", "client auth"},
+			Expiry:       expiry,
+			ExpiryString: DefaultNodeCertExpiration,
+		},
+	}
+}
+
+// CreateRootCA creates a brand new self-signed root CA, writes the
+// certificate and key to rootCAPath/rootCAKeyPath and returns a RootCA
+// backed by the new on-disk key.
+func CreateRootCA(rootCAPath, rootCAKeyPath, rootCN string) (*RootCA, error) {
+	req := &csr.CertificateRequest{
+		CN:         rootCN,
+		KeyRequest: &csr.BasicKeyRequest{A: RootKeyAlgo, S: RootKeySize},
+		CA:         &csr.CAConfig{Expiry: RootCAExpiration},
+	}
+
+	cert, _, key, err := initca.New(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root CA: %v", err)
+	}
+
+	if err := ioutil.WriteFile(rootCAPath, cert, 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(rootCAKeyPath, key, 0600); err != nil {
+		return nil, err
+	}
+
+	return NewRootCA(cert, key)
+}
+
+// GetRootCA reads the PEM-encoded root CA certificate from rootCAPath.
+func GetRootCA(rootCAPath string) ([]byte, error) {
+	cert, err := ioutil.ReadFile(rootCAPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := helpers.ParseCertificatePEM(cert); err != nil {
+		return nil, fmt.Errorf("invalid root CA certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// GetRootCACertPool parses a PEM-encoded root CA certificate into a usable
+// x509.CertPool.
+func GetRootCACertPool(rootCACert []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(rootCACert) {
+		return nil, fmt.Errorf("failed to append root CA certificate to pool")
+	}
+	return pool, nil
+}
+
+// generateNewCSR creates a fresh ECDSA key and a CSR for it, returning both
+// as PEM blocks.
+func generateNewCSR() (csrPEM, key []byte, err error) {
+	req := &csr.CertificateRequest{
+		KeyRequest: &csr.BasicKeyRequest{A: RootKeyAlgo, S: RootKeySize},
+	}
+	return csr.ParseRequest(req)
+}
+
+// GenerateAndWriteNewCSR creates a new CSR and key pair, persists both to
+// csrPath/keyPath with restrictive permissions and returns them.
+func GenerateAndWriteNewCSR(csrPath, keyPath string) (csr, key []byte, err error) {
+	csr, key, err = generateNewCSR()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ioutil.WriteFile(csrPath, csr, 0644); err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, nil, err
+	}
+
+	return csr, key, nil
+}
+
+// GenerateAndSignNewTLSCert generates a new CSR, signs it with rootCA using
+// cn/ou as the certificate's identity and persists the resulting certificate
+// and key to certPath/keyPath.
+func GenerateAndSignNewTLSCert(rootCA *RootCA, certPath, keyPath, cn, ou string) ([]byte, error) {
+	csr, key, err := generateNewCSR()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ParseValidateAndSignCSR(rootCA, csr, cn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(certPath, cert, 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// ParseValidateAndSignCSR parses csrPEM, overrides its subject with cn/ou and
+// signs it with rootCA, returning the PEM-encoded signed certificate. The
+// caller supplied cn/ou always win over whatever the CSR itself asked for,
+// so a malicious CSR cannot smuggle in an unexpected identity.
+func ParseValidateAndSignCSR(rootCA *RootCA, csrPEM []byte, cn, ou string) ([]byte, error) {
+	if _, err := helpers.ParseCSRPEM(csrPEM); err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %v", err)
+	}
+
+	req := cfsigner.SignRequest{
+		Request: string(csrPEM),
+		Subject: &cfsigner.Subject{
+			CN: cn,
+			Names: []csr.Name{
+				{OU: ou},
+			},
+		},
+	}
+
+	cert, err := rootCA.cfsslSigner.Sign(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CSR: %v", err)
+	}
+
+	return cert, nil
+}
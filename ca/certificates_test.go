@@ -24,7 +24,7 @@ func TestCreateRootCA(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	_, _, err = CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	_, err = CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
 	assert.NoError(t, err)
 
 	perms, err := permbits.Stat(paths.RootCACert)
@@ -44,12 +44,12 @@ func TestGetRootCA(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	_, rootCACert, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
 	assert.NoError(t, err)
 
 	rootCACertificate, err := GetRootCA(paths.RootCACert)
 	assert.NoError(t, err)
-	assert.Equal(t, rootCACert, rootCACertificate)
+	assert.Equal(t, rootCA.Cert, rootCACertificate)
 }
 
 func TestGenerateAndSignNewTLSCert(t *testing.T) {
@@ -59,10 +59,10 @@ func TestGenerateAndSignNewTLSCert(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	signer, rootCACert, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
 	assert.NoError(t, err)
 
-	_, err = GenerateAndSignNewTLSCert(signer, rootCACert, paths.ManagerCert, paths.ManagerKey, "CN", "OU")
+	_, err = GenerateAndSignNewTLSCert(rootCA, paths.ManagerCert, paths.ManagerKey, "CN", "OU")
 	assert.NoError(t, err)
 
 	perms, err := permbits.Stat(paths.ManagerCert)
@@ -107,13 +107,13 @@ func TestParseValidateAndSignCSR(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	signer, _, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
 	assert.NoError(t, err)
 
 	csr, _, err := generateNewCSR()
 	assert.NoError(t, err)
 
-	signedCert, err := ParseValidateAndSignCSR(signer, csr, "CN", "OU")
+	signedCert, err := ParseValidateAndSignCSR(rootCA, csr, "CN", "OU")
 	assert.NoError(t, err)
 	assert.NotNil(t, signedCert)
 
@@ -133,7 +133,7 @@ func TestParseValidateAndSignMaliciousCSR(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	signer, _, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
 	assert.NoError(t, err)
 
 	req := &cfcsr.CertificateRequest{
@@ -151,7 +151,7 @@ func TestParseValidateAndSignMaliciousCSR(t *testing.T) {
 	csr, _, err := cfcsr.ParseRequest(req)
 	assert.NoError(t, err)
 
-	signedCert, err := ParseValidateAndSignCSR(signer, csr, "CN", "OU")
+	signedCert, err := ParseValidateAndSignCSR(rootCA, csr, "CN", "OU")
 	assert.NoError(t, err)
 	assert.NotNil(t, signedCert)
 
@@ -172,9 +172,9 @@ func TestGetRemoteCA(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	signer, rootCACert, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
 	assert.NoError(t, err)
-	managerConfig, err := genManagerSecurityConfig(signer, rootCACert, tempBaseDir)
+	managerConfig, err := genManagerSecurityConfig(rootCA, tempBaseDir)
 	assert.NoError(t, err)
 
 	ctx := context.Background()
@@ -193,7 +193,7 @@ func TestGetRemoteCA(t *testing.T) {
 	}()
 
 	shaHash := sha256.New()
-	shaHash.Write(rootCACert)
+	shaHash.Write(rootCA.Cert)
 	md := shaHash.Sum(nil)
 	mdStr := hex.EncodeToString(md)
 
@@ -212,9 +212,9 @@ func TestGetRemoteCAInvalidHash(t *testing.T) {
 
 	paths := NewConfigPaths(tempBaseDir)
 
-	signer, rootCACert, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
 	assert.NoError(t, err)
-	managerConfig, err := genManagerSecurityConfig(signer, rootCACert, tempBaseDir)
+	managerConfig, err := genManagerSecurityConfig(rootCA, tempBaseDir)
 	assert.NoError(t, err)
 
 	ctx := context.Background()
@@ -234,4 +234,4 @@ func TestGetRemoteCAInvalidHash(t *testing.T) {
 
 	_, err = GetRemoteCA(ctx, l.Addr().String(), "2d2f968475269f0dde5299427cf74348ee1d6115b95c6e3f283e5a4de8da445b")
 	assert.Error(t, err)
-}
\ No newline at end of file
+}
@@ -0,0 +1,295 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	rootCACertFilename  = "root-ca.crt"
+	rootCAKeyFilename   = "root-ca.key"
+	managerCertFilename = "manager.crt"
+	managerKeyFilename  = "manager.key"
+	managerCSRFilename  = "manager.csr"
+)
+
+// ConfigPaths groups the well-known on-disk locations of the certificates,
+// keys and CSRs a node keeps under its state directory.
+type ConfigPaths struct {
+	RootCACert  string
+	RootCAKey   string
+	ManagerCert string
+	ManagerKey  string
+	ManagerCSR  string
+}
+
+// NewConfigPaths returns a ConfigPaths rooted at baseDir.
+func NewConfigPaths(baseDir string) *ConfigPaths {
+	return &ConfigPaths{
+		RootCACert:  filepath.Join(baseDir, rootCACertFilename),
+		RootCAKey:   filepath.Join(baseDir, rootCAKeyFilename),
+		ManagerCert: filepath.Join(baseDir, managerCertFilename),
+		ManagerKey:  filepath.Join(baseDir, managerKeyFilename),
+		ManagerCSR:  filepath.Join(baseDir, managerCSRFilename),
+	}
+}
+
+// ExternalCAProtocol identifies the wire protocol spoken with an external CA.
+type ExternalCAProtocol string
+
+// CFSSL is the only external CA protocol currently supported: it POSTs the
+// CSR to a remote cfssl signing endpoint and expects a cfssl-shaped response
+// back.
+const CFSSL ExternalCAProtocol = "cfssl"
+
+// ExternalCAConfig describes a single remote CA that CSR signing can be
+// delegated to instead of (or before falling back to) the local root signer.
+type ExternalCAConfig struct {
+	// Protocol is the wire protocol to speak with this CA. Only CFSSL is
+	// currently understood.
+	Protocol ExternalCAProtocol
+	// URL is the base address of the external CA, e.g. "https://ca.example.com".
+	URL string
+	// CACert is the PEM-encoded certificate used to authenticate the
+	// external CA's TLS endpoint.
+	CACert []byte
+	// Options carries protocol-specific settings. The CFSSL backend reads
+	// only the "profile" key, which it sends as the signing profile; other
+	// keys are ignored.
+	Options map[string]string
+}
+
+// CAConfig groups the external CAs a manager has been configured to delegate
+// signing to. An empty CAConfig means CSRs are always signed locally.
+type CAConfig struct {
+	ExternalCAs []*ExternalCAConfig
+}
+
+// SecurityConfig holds the runtime TLS material and signing configuration a
+// manager or worker uses to authenticate itself and its peers.
+type SecurityConfig struct {
+	// RootCA is the root CA whose Pool this node trusts and, on a manager,
+	// whose signer signs CSRs. It must not be nil: NewSecurityConfig,
+	// TrustBundle and certificate renewal all dereference it.
+	RootCA *RootCA
+
+	// Paths locates this node's certificate, key and CSR on disk, so that
+	// renewal can find and atomically replace them.
+	Paths *ConfigPaths
+
+	ClientTLSCreds credentials.TransportCredentials
+	ServerTLSCreds credentials.TransportCredentials
+
+	// CAConfig carries the set of external CAs, if any, that CSR signing
+	// should be delegated to before falling back to the local root signer.
+	CAConfig CAConfig
+
+	// JoinTokens tracks the secrets currently accepted from nodes enrolling
+	// via IssueNodeCertificate. Nil on nodes that don't accept enrollments.
+	JoinTokens *JoinTokens
+
+	// ForceRotate is bumped by RotateNow to tell RenewTLSConfig to request
+	// a new certificate immediately, regardless of the current one's
+	// expiration.
+	ForceRotate uint64
+
+	// rotateSignal wakes a running RenewTLSConfig loop as soon as RotateNow
+	// is called, rather than making it wait out its current poll interval.
+	rotateSignal chan struct{}
+
+	// mu guards cert, pool and trustBundle, which UpdateTLSCertificate and
+	// UpdateRootCA read and swap together so that a renewal landing
+	// mid-rotation (or vice versa) never rebuilds ClientTLSCreds/
+	// ServerTLSCreds from a stale combination of the two.
+	mu sync.Mutex
+	// cert is the node's own leaf certificate, last installed by
+	// NewSecurityConfig or UpdateTLSCertificate.
+	cert tls.Certificate
+	// pool is the trust pool currently backing ClientTLSCreds/ServerTLSCreds:
+	// RootCA.Pool outside of a rotation, or the wider pool parsed from a
+	// trust bundle while one is in flight. UpdateTLSCertificate reuses this
+	// so that renewing the node's own certificate never narrows the trust
+	// pool a concurrent UpdateRootCA has widened.
+	pool *x509.CertPool
+	// trustBundle is served from GetRootCACertificate in place of RootCA.Cert
+	// alone while a rotation is in flight. See UpdateRootCA.
+	trustBundle []byte
+
+	clientCreds *mutableTLSCreds
+	serverCreds *mutableTLSCreds
+}
+
+// RotateNow tells any running RenewTLSConfig loop for this SecurityConfig to
+// request a new certificate immediately, regardless of how long the current
+// one has left to live.
+func (s *SecurityConfig) RotateNow() {
+	atomic.AddUint64(&s.ForceRotate, 1)
+	select {
+	case s.rotateSignal <- struct{}{}:
+	default:
+		// A signal is already pending; the loop will see the bumped
+		// ForceRotate as soon as it wakes for that one.
+	}
+}
+
+// NewSecurityConfig builds a SecurityConfig around rootCA and a node TLS
+// certificate, wiring ClientTLSCreds/ServerTLSCreds so that a later call to
+// UpdateTLSCertificate swaps the active certificate without invalidating
+// connections that already completed their TLS handshake.
+func NewSecurityConfig(rootCA *RootCA, paths *ConfigPaths, cert tls.Certificate) (*SecurityConfig, error) {
+	baseTLSConfig := func() *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      rootCA.Pool,
+			ClientCAs:    rootCA.Pool,
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	clientCreds := newMutableTLSCreds(baseTLSConfig())
+	serverCreds := newMutableTLSCreds(baseTLSConfig())
+
+	return &SecurityConfig{
+		RootCA:         rootCA,
+		Paths:          paths,
+		ClientTLSCreds: clientCreds,
+		ServerTLSCreds: serverCreds,
+		rotateSignal:   make(chan struct{}, 1),
+		cert:           cert,
+		pool:           rootCA.Pool,
+		clientCreds:    clientCreds,
+		serverCreds:    serverCreds,
+	}, nil
+}
+
+// UpdateTLSCertificate swaps the certificate presented by ClientTLSCreds and
+// ServerTLSCreds in place, so existing connections keep running under the
+// old certificate while new ones pick up cert. The trust pool those
+// credentials verify peers against is left as whatever UpdateRootCA last
+// installed, so a certificate renewal landing mid-rotation doesn't narrow it
+// back down.
+func (s *SecurityConfig) UpdateTLSCertificate(cert tls.Certificate) {
+	s.mu.Lock()
+	s.cert = cert
+	pool := s.pool
+	s.mu.Unlock()
+
+	s.setTLSConfig(cert, pool)
+}
+
+// UpdateRootCA swaps in rootCA as the root CA this node trusts (and, on a
+// manager, signs with), rebuilding ClientTLSCreds/ServerTLSCreds in place so
+// already-established connections keep running while new handshakes are
+// verified against the new trust pool. trustBundle, when non-nil, widens
+// that pool to every certificate it contains instead of rootCA.Cert alone,
+// so a node mid-rotation (see RotateRootCA) can keep validating, and being
+// validated by, peers that haven't picked up the same side of the rotation
+// yet; pass nil once the rotation has retired the old root.
+func (s *SecurityConfig) UpdateRootCA(rootCA *RootCA, trustBundle []byte) error {
+	pool := rootCA.Pool
+	if len(trustBundle) > 0 {
+		p, err := GetRootCACertPool(trustBundle)
+		if err != nil {
+			return err
+		}
+		pool = p
+	}
+
+	s.mu.Lock()
+	s.RootCA = rootCA
+	s.trustBundle = trustBundle
+	s.pool = pool
+	cert := s.cert
+	s.mu.Unlock()
+
+	s.setTLSConfig(cert, pool)
+	return nil
+}
+
+// TrustBundle returns the PEM blob this node should serve from
+// GetRootCACertificate: the bundle installed by the most recent UpdateRootCA
+// call, or RootCA.Cert alone once a rotation has retired the old root.
+func (s *SecurityConfig) TrustBundle() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.trustBundle) > 0 {
+		return s.trustBundle
+	}
+	return s.RootCA.Cert
+}
+
+// CurrentRootCA returns the RootCA most recently installed by UpdateRootCA,
+// for callers (such as Server) that sign or validate against it concurrently
+// with a rotation swapping it out.
+func (s *SecurityConfig) CurrentRootCA() *RootCA {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RootCA
+}
+
+// setTLSConfig rebuilds ClientTLSCreds/ServerTLSCreds around cert and pool.
+func (s *SecurityConfig) setTLSConfig(cert tls.Certificate, pool *x509.CertPool) {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	s.clientCreds.set(tlsConfig)
+	s.serverCreds.set(tlsConfig)
+}
+
+// mutableTLSCreds implements credentials.TransportCredentials by delegating
+// to a swappable inner TransportCredentials. gRPC only ever sees this
+// wrapper, so replacing the inner credentials (e.g. after certificate
+// renewal) does not require recreating the grpc.Server or its listener.
+type mutableTLSCreds struct {
+	mu    sync.RWMutex
+	creds credentials.TransportCredentials
+}
+
+func newMutableTLSCreds(tlsConfig *tls.Config) *mutableTLSCreds {
+	return &mutableTLSCreds{creds: credentials.NewTLS(tlsConfig)}
+}
+
+func (m *mutableTLSCreds) set(tlsConfig *tls.Config) {
+	m.mu.Lock()
+	m.creds = credentials.NewTLS(tlsConfig)
+	m.mu.Unlock()
+}
+
+func (m *mutableTLSCreds) get() credentials.TransportCredentials {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.creds
+}
+
+func (m *mutableTLSCreds) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return m.get().ClientHandshake(ctx, addr, rawConn)
+}
+
+func (m *mutableTLSCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return m.get().ServerHandshake(rawConn)
+}
+
+func (m *mutableTLSCreds) Info() credentials.ProtocolInfo {
+	return m.get().Info()
+}
+
+func (m *mutableTLSCreds) Clone() credentials.TransportCredentials {
+	return m.get().Clone()
+}
+
+func (m *mutableTLSCreds) OverrideServerName(name string) error {
+	return m.get().OverrideServerName(name)
+}
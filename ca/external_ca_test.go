@@ -0,0 +1,65 @@
+package ca
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalCASignCFSSL(t *testing.T) {
+	tempBaseDir := t.TempDir()
+	paths := NewConfigPaths(tempBaseDir)
+
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "rootCN")
+	assert.NoError(t, err)
+
+	csrPEM, _, err := generateNewCSR()
+	assert.NoError(t, err)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/cfssl/sign", r.URL.Path)
+
+		var req cfsslSignRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		cert, err := ParseValidateAndSignCSR(rootCA, []byte(req.Request), req.Subject.CN, req.Subject.Names[0].OU)
+		assert.NoError(t, err)
+
+		assert.NoError(t, json.NewEncoder(w).Encode(cfsslSignResponse{
+			Success: true,
+			Result: &struct {
+				Certificate string `json:"certificate"`
+			}{Certificate: string(cert)},
+		}))
+	}))
+	defer server.Close()
+
+	caCertPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+
+	externalCA := NewExternalCA([]*ExternalCAConfig{
+		{
+			Protocol: CFSSL,
+			URL:      server.URL,
+			CACert:   caCertPEM,
+		},
+	})
+
+	signedCert, err := externalCA.Sign(csrPEM, "external-CN", "external-OU")
+	assert.NoError(t, err)
+	assert.NotNil(t, signedCert)
+
+	parsedCert, err := helpers.ParseCertificatesPEM(signedCert)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(parsedCert))
+	assert.Equal(t, "external-CN", parsedCert[0].Subject.CommonName)
+	assert.Equal(t, 1, len(parsedCert[0].Subject.OrganizationalUnit))
+	assert.Equal(t, "external-OU", parsedCert[0].Subject.OrganizationalUnit[0])
+}
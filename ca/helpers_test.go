@@ -0,0 +1,35 @@
+package ca
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+)
+
+// genManagerSecurityConfig builds a SecurityConfig for a manager node backed
+// by a certificate signed with rootCA, under tempBaseDir. It is shared by
+// tests that need a working manager identity to stand up a CA server.
+func genManagerSecurityConfig(rootCA *RootCA, tempBaseDir string) (*SecurityConfig, error) {
+	paths := NewConfigPaths(tempBaseDir)
+
+	certPEM, err := GenerateAndSignNewTLSCert(rootCA, paths.ManagerCert, paths.ManagerKey, "swarm-test-manager", "swarm-manager")
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(paths.ManagerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	securityConfig, err := NewSecurityConfig(rootCA, paths, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return securityConfig, nil
+}
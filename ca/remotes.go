@@ -0,0 +1,40 @@
+package ca
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Remotes is a simple round-robin picker over a set of manager addresses,
+// used to fail over between managers when contacting the cluster CA.
+type Remotes struct {
+	mu    sync.Mutex
+	addrs []string
+	next  int
+}
+
+// NewRemotes returns a Remotes that cycles through addrs in order.
+func NewRemotes(addrs ...string) *Remotes {
+	return &Remotes{addrs: addrs}
+}
+
+// Select returns the next address to try.
+func (r *Remotes) Select() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.addrs) == 0 {
+		return "", fmt.Errorf("no remote addresses available")
+	}
+
+	addr := r.addrs[r.next%len(r.addrs)]
+	r.next++
+	return addr, nil
+}
+
+// Len returns the number of addresses Remotes knows about.
+func (r *Remotes) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.addrs)
+}
@@ -0,0 +1,201 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/docker/swarm-v2/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// renewalPollInterval bounds how often RenewTLSConfig wakes up to check
+// whether the current certificate needs renewing, even when nothing else
+// tells it to.
+const renewalPollInterval = 5 * time.Minute
+
+// now is overridden in tests to drive RenewTLSConfig with a fake clock.
+var now = time.Now
+
+// RenewTLSConfig runs until ctx is cancelled, watching the expiration of the
+// certificate at securityConfig.Paths.ManagerCert and requesting a new one
+// renewBefore its NotAfter, or immediately whenever RotateNow has bumped
+// securityConfig.ForceRotate. Renewed certificates are fetched by dialing
+// through remotes, then swapped onto disk atomically and reloaded into
+// securityConfig in place, without dropping connections already using the
+// old certificate.
+func RenewTLSConfig(ctx context.Context, securityConfig *SecurityConfig, remotes *Remotes, renewBefore time.Duration) error {
+	lastForceRotate := atomic.LoadUint64(&securityConfig.ForceRotate)
+
+	for {
+		wait := renewalPollInterval
+		if cert, err := currentCertificate(securityConfig.Paths.ManagerCert); err == nil {
+			if untilRenewal := cert.NotAfter.Sub(now()) - renewBefore; untilRenewal < wait {
+				wait = untilRenewal
+			}
+		}
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-securityConfig.rotateSignal:
+		case <-time.After(wait):
+		}
+
+		forceRotate := atomic.LoadUint64(&securityConfig.ForceRotate)
+		needsRenewal := forceRotate != lastForceRotate
+		if cert, err := currentCertificate(securityConfig.Paths.ManagerCert); err == nil {
+			if now().Add(renewBefore).After(cert.NotAfter) {
+				needsRenewal = true
+			}
+		}
+		if !needsRenewal {
+			continue
+		}
+
+		if err := renewCertificate(ctx, securityConfig, remotes); err != nil {
+			// A transient failure (e.g. every remote is briefly
+			// unreachable) shouldn't tear down the loop; just retry on the
+			// next poll. In particular, don't advance lastForceRotate here:
+			// a forced rotation that failed to land must keep being retried
+			// on every poll, not silently fall back to the expiry-based
+			// check until the cert is naturally close to NotAfter.
+			continue
+		}
+		lastForceRotate = forceRotate
+	}
+}
+
+// currentCertificate reads and parses the leaf certificate at certPath.
+func currentCertificate(certPath string) (*x509.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return helpers.ParseCertificatePEM(certPEM)
+}
+
+// renewCertificate generates a fresh CSR, has it signed through remotes, and
+// atomically replaces the on-disk certificate/key before reloading
+// securityConfig's TLS credentials.
+func renewCertificate(ctx context.Context, securityConfig *SecurityConfig, remotes *Remotes) error {
+	paths := securityConfig.Paths
+
+	csr, key, err := generateNewCSR()
+	if err != nil {
+		return err
+	}
+
+	certPEM, err := GetRemoteSignedCertificate(ctx, csr, "", securityConfig.RootCA.Pool, remotes, securityConfig.ClientTLSCreds)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicWriteFile(paths.ManagerCert, certPEM, 0644); err != nil {
+		return err
+	}
+	if err := atomicWriteFile(paths.ManagerKey, key, 0600); err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, key)
+	if err != nil {
+		return fmt.Errorf("failed to load renewed certificate: %v", err)
+	}
+
+	securityConfig.UpdateTLSCertificate(cert)
+	return nil
+}
+
+// atomicWriteFile writes data to path by writing to a temporary file in the
+// same directory and renaming it over path, so readers never observe a
+// partially written certificate or key.
+func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// GetRemoteSignedCertificate requests a signed certificate for csr from one
+// of remotes, failing over between them. creds authenticates the connection
+// when the caller already holds a valid certificate (renewal); pass nil for
+// a brand new node, which falls back to a TLS config that only trusts
+// rootPool, so token is the caller's sole proof of identity.
+func GetRemoteSignedCertificate(ctx context.Context, csr []byte, token string, rootPool *x509.CertPool, remotes *Remotes, creds credentials.TransportCredentials) ([]byte, error) {
+	if creds == nil {
+		creds = credentials.NewTLS(&tls.Config{RootCAs: rootPool})
+	}
+
+	var lastErr error
+	for i := 0; i < remotes.Len(); i++ {
+		addr, err := remotes.Select()
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := requestSignedCertificate(ctx, addr, csr, token, creds)
+		if err == nil {
+			return cert, nil
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to reach any remote manager, last error: %v", lastErr)
+}
+
+// requestSignedCertificate dials addr and requests a signed certificate for
+// csr. A brand new node with no certificate of its own has nothing for creds
+// to present, so it enrolls via IssueNodeCertificate using token as its proof
+// of identity; a node renewing an existing certificate already authenticates
+// the connection with creds and has no token to offer, so it calls
+// RenewCertificate instead.
+func requestSignedCertificate(ctx context.Context, addr string, csr []byte, token string, creds credentials.TransportCredentials) ([]byte, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := api.NewCAClient(conn)
+
+	if token != "" {
+		resp, err := client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{Csr: csr, Token: token})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Certificate, nil
+	}
+
+	resp, err := client.RenewCertificate(ctx, &api.RenewCertificateRequest{Csr: csr})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Certificate, nil
+}
@@ -0,0 +1,145 @@
+package ca
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/docker/swarm-v2/api"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestRenewTLSConfig(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+
+	managerConfig, err := genManagerSecurityConfig(rootCA, tempBaseDir)
+	assert.NoError(t, err)
+
+	opts := []grpc.ServerOption{grpc.Creds(managerConfig.ServerTLSCreds)}
+	grpcServer := grpc.NewServer(opts...)
+	caServer := NewServer(managerConfig)
+	api.RegisterCAServer(grpcServer, caServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(l)
+	defer grpcServer.Stop()
+
+	oldCertPEM, err := ioutil.ReadFile(paths.ManagerCert)
+	assert.NoError(t, err)
+
+	remotes := NewRemotes(l.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeNow := time.Now()
+	now = func() time.Time { return fakeNow }
+	defer func() { now = time.Now }()
+
+	go RenewTLSConfig(ctx, managerConfig, remotes, 1*time.Hour)
+
+	// Force an immediate renewal rather than waiting out a real expiration;
+	// the fresh certificate is nowhere near renewBefore of its NotAfter, so
+	// the loop otherwise sleeps for renewalPollInterval and doesn't race this.
+	managerConfig.RotateNow()
+
+	var newCertPEM []byte
+	for i := 0; i < 500; i++ {
+		newCertPEM, err = ioutil.ReadFile(paths.ManagerCert)
+		if err == nil && string(newCertPEM) != string(oldCertPEM) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotEqual(t, string(oldCertPEM), string(newCertPEM))
+
+	newCert, err := helpers.ParseCertificatePEM(newCertPEM)
+	assert.NoError(t, err)
+
+	_, err = newCert.Verify(x509.VerifyOptions{Roots: rootCA.Pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+}
+
+func TestRenewTLSConfigExpiry(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+
+	managerConfig, err := genManagerSecurityConfig(rootCA, tempBaseDir)
+	assert.NoError(t, err)
+
+	opts := []grpc.ServerOption{grpc.Creds(managerConfig.ServerTLSCreds)}
+	grpcServer := grpc.NewServer(opts...)
+	caServer := NewServer(managerConfig)
+	api.RegisterCAServer(grpcServer, caServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(l)
+	defer grpcServer.Stop()
+
+	oldCertPEM, err := ioutil.ReadFile(paths.ManagerCert)
+	assert.NoError(t, err)
+	oldCert, err := helpers.ParseCertificatePEM(oldCertPEM)
+	assert.NoError(t, err)
+
+	remotes := NewRemotes(l.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start the fake clock just clear of renewBefore's threshold, then
+	// advance it past NotAfter while the loop is asleep on that short poll
+	// wait: the loop should pick the expiry up on its own when it next
+	// checks, with no call to RotateNow, exercising the NotAfter-driven
+	// renewal path (ca/renew.go:41,59) rather than the ForceRotate one.
+	const renewBefore = 200 * time.Millisecond
+	var clockMu sync.Mutex
+	fakeNow := oldCert.NotAfter.Add(-renewBefore - 300*time.Millisecond)
+	now = func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return fakeNow
+	}
+	defer func() { now = time.Now }()
+
+	go RenewTLSConfig(ctx, managerConfig, remotes, renewBefore)
+
+	clockMu.Lock()
+	fakeNow = oldCert.NotAfter.Add(50 * time.Millisecond)
+	clockMu.Unlock()
+
+	var newCertPEM []byte
+	for i := 0; i < 500; i++ {
+		newCertPEM, err = ioutil.ReadFile(paths.ManagerCert)
+		if err == nil && string(newCertPEM) != string(oldCertPEM) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotEqual(t, string(oldCertPEM), string(newCertPEM))
+
+	newCert, err := helpers.ParseCertificatePEM(newCertPEM)
+	assert.NoError(t, err)
+
+	_, err = newCert.Verify(x509.VerifyOptions{Roots: rootCA.Pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+}
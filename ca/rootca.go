@@ -0,0 +1,77 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/helpers"
+	cfsigner "github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+// RootCA bundles a cluster's root CA certificate with whatever holds the
+// matching private key. The key itself is only ever required to implement
+// crypto.Signer, so it can just as well be parsed from a PEM file on disk
+// (NewRootCA) as live behind a PKCS#11 HSM, a cloud KMS, or an ssh-agent-style
+// socket that never lets the key material leave it (NewRootCAFromKMS).
+type RootCA struct {
+	Cert   []byte
+	Pool   *x509.CertPool
+	Signer crypto.Signer
+
+	cfsslSigner cfsigner.Signer
+}
+
+// NewRootCA builds a RootCA from a PEM-encoded certificate and the PEM-encoded
+// private key that signed it.
+func NewRootCA(certPEM, keyPEM []byte) (*RootCA, error) {
+	parsedKey, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse root CA key: %v", err)
+	}
+
+	s, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("root CA key does not support signing")
+	}
+
+	return newRootCA(certPEM, s)
+}
+
+// NewRootCAFromKMS builds a RootCA whose private key never leaves the KMS
+// identified by kmsURI. dial is responsible for speaking whatever protocol
+// that KMS expects (PKCS#11, a cloud KMS API, an ssh-agent-style socket) and
+// returning a crypto.Signer that performs signing operations against it.
+func NewRootCAFromKMS(certPEM []byte, kmsURI string, dial func(uri string) (crypto.Signer, error)) (*RootCA, error) {
+	s, err := dial(kmsURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach KMS at %s: %v", kmsURI, err)
+	}
+
+	return newRootCA(certPEM, s)
+}
+
+func newRootCA(certPEM []byte, s crypto.Signer) (*RootCA, error) {
+	parsedCert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse root CA certificate: %v", err)
+	}
+
+	pool, err := GetRootCACertPool(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	cfsslSigner, err := local.NewSigner(s, parsedCert, cfsigner.DefaultSigAlgo(s), signingPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build CA signer: %v", err)
+	}
+
+	return &RootCA{
+		Cert:        certPEM,
+		Pool:        pool,
+		Signer:      s,
+		cfsslSigner: cfsslSigner,
+	}, nil
+}
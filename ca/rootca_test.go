@@ -0,0 +1,99 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/initca"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKMSSigner is an in-memory stand-in for a PKCS#11/cloud-KMS/ssh-agent
+// style signer: the private key lives only inside it, and callers can only
+// ever ask it to sign things or hand back the public key.
+type fakeKMSSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func newFakeKMS() (*fakeKMSSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeKMSSigner{key: key}, nil
+}
+
+func (f *fakeKMSSigner) Public() crypto.PublicKey {
+	return &f.key.PublicKey
+}
+
+func (f *fakeKMSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return f.key.Sign(rand, digest, opts)
+}
+
+func TestNewRootCAFromKMS(t *testing.T) {
+	kms, err := newFakeKMS()
+	assert.NoError(t, err)
+
+	// The root CA certificate has to be generated against the KMS's public
+	// key: NewRootCAFromKMS trusts certPEM as-is, it doesn't mint one.
+	certPEM, _, err := initca.NewFromSigner(&csr.CertificateRequest{
+		CN:         "kms-rootCN",
+		KeyRequest: &csr.BasicKeyRequest{A: RootKeyAlgo, S: RootKeySize},
+		CA:         &csr.CAConfig{Expiry: RootCAExpiration},
+	}, kms)
+	assert.NoError(t, err)
+
+	dial := func(uri string) (crypto.Signer, error) {
+		if uri != "kms://fake" {
+			return nil, fmt.Errorf("unknown KMS URI %q", uri)
+		}
+		return kms, nil
+	}
+
+	kmsRootCA, err := NewRootCAFromKMS(certPEM, "kms://fake", dial)
+	assert.NoError(t, err)
+	assert.Equal(t, certPEM, kmsRootCA.Cert)
+
+	nodeCSR, _, err := generateNewCSR()
+	assert.NoError(t, err)
+
+	signedCert, err := ParseValidateAndSignCSR(kmsRootCA, nodeCSR, "CN", "OU")
+	assert.NoError(t, err)
+
+	parsedCert, err := helpers.ParseCertificatePEM(signedCert)
+	assert.NoError(t, err)
+
+	_, err = parsedCert.Verify(x509.VerifyOptions{
+		Roots:     kmsRootCA.Pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err)
+}
+
+func TestNewRootCAFromKMSUnreachable(t *testing.T) {
+	kms, err := newFakeKMS()
+	assert.NoError(t, err)
+
+	certPEM, _, err := initca.NewFromSigner(&csr.CertificateRequest{
+		CN:         "kms-rootCN",
+		KeyRequest: &csr.BasicKeyRequest{A: RootKeyAlgo, S: RootKeySize},
+		CA:         &csr.CAConfig{Expiry: RootCAExpiration},
+	}, kms)
+	assert.NoError(t, err)
+
+	dial := func(uri string) (crypto.Signer, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	_, err = NewRootCAFromKMS(certPEM, "kms://unreachable", dial)
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/initca"
+	"golang.org/x/net/context"
+)
+
+// RotationPhase identifies a step of an in-flight root CA rotation.
+type RotationPhase string
+
+const (
+	// RotationPhasePrepare generates the new root CA key and certificate.
+	RotationPhasePrepare RotationPhase = "prepare"
+	// RotationPhaseCrossSign cross-signs the new root with the old one, so
+	// that a certificate issued by either root can be validated through the
+	// trust bundle during the transition window.
+	RotationPhaseCrossSign RotationPhase = "cross-sign"
+	// RotationPhaseDistribute is reached once the trust bundle is ready to
+	// be (or has been) handed out; every node is expected to add it to its
+	// trust store before the rotation moves on.
+	RotationPhaseDistribute RotationPhase = "distribute"
+	// RotationPhasePromote switches new certificates over to being signed
+	// by the new root.
+	RotationPhasePromote RotationPhase = "promote"
+	// RotationPhaseRetire drops the old root, so only the new root is
+	// served from GetRootCACertificate.
+	RotationPhaseRetire RotationPhase = "retire"
+)
+
+// RotationState is the persisted state of an in-flight root CA rotation.
+type RotationState struct {
+	Phase       RotationPhase
+	OldRootCert []byte
+	NewRootCert []byte
+	TrustBundle []byte
+}
+
+// RotationStore persists a RotationState across the phases of a rotation, so
+// it can be resumed if a manager restarts partway through. RotationStore is
+// the seam that keeps this package from needing to know what storage is
+// wired up behind it. The only implementation in this package is
+// MemoryRotationStore; no raft-backed RotationStore is provided here.
+type RotationStore interface {
+	SaveRotationState(*RotationState) error
+}
+
+// MemoryRotationStore is a RotationStore that only keeps state in memory.
+// It's useful for tests, and for single-node setups with no raft store to
+// persist into.
+type MemoryRotationStore struct {
+	state *RotationState
+}
+
+// NewMemoryRotationStore returns an empty MemoryRotationStore.
+func NewMemoryRotationStore() *MemoryRotationStore {
+	return &MemoryRotationStore{}
+}
+
+// SaveRotationState implements RotationStore.
+func (m *MemoryRotationStore) SaveRotationState(state *RotationState) error {
+	m.state = state
+	return nil
+}
+
+// State returns the most recently saved RotationState, or nil if none has
+// been saved yet.
+func (m *MemoryRotationStore) State() *RotationState {
+	return m.state
+}
+
+// RotateRootCA generates a new root CA with common name newCN, cross-signs it
+// with oldRoot, and walks the rotation through its Prepare, CrossSign and
+// Distribute phases, persisting state into store after each one. Reaching
+// Distribute widens securityConfig's own mTLS trust pool (ClientTLSCreds/
+// ServerTLSCreds) to the returned trust bundle, while leaving it signing
+// with oldRoot still, so this node can immediately validate (and be
+// validated by) peers on either side of the rotation without dropping its
+// own identity yet. It returns the new RootCA and the trust bundle that must
+// reach every other node in the cluster the same way before the rotation is
+// promoted: a PEM concatenation of the old root certificate, the new root
+// certificate and the cross-signed certificate, so a node that still only
+// trusts the old root can validate a peer holding a certificate signed by
+// the new root (and vice versa) for as long as the rotation is in flight.
+//
+// Promote and Retire are separate, later steps (see PromoteRootCA and
+// RetireRootCA): they shouldn't run until the caller has confirmed every
+// node in the cluster has picked up the trust bundle returned here.
+func RotateRootCA(ctx context.Context, securityConfig *SecurityConfig, oldRoot *RootCA, newCN string, store RotationStore) (*RootCA, []byte, error) {
+	state := &RotationState{Phase: RotationPhasePrepare, OldRootCert: oldRoot.Cert}
+	if err := store.SaveRotationState(state); err != nil {
+		return nil, nil, fmt.Errorf("failed to persist rotation state: %v", err)
+	}
+
+	req := &csr.CertificateRequest{
+		CN:         newCN,
+		KeyRequest: &csr.BasicKeyRequest{A: RootKeyAlgo, S: RootKeySize},
+		CA:         &csr.CAConfig{Expiry: RootCAExpiration},
+	}
+	newRootCertPEM, _, newRootKeyPEM, err := initca.New(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new root CA: %v", err)
+	}
+
+	newRoot, err := NewRootCA(newRootCertPEM, newRootKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state.Phase = RotationPhaseCrossSign
+	state.NewRootCert = newRoot.Cert
+	if err := store.SaveRotationState(state); err != nil {
+		return nil, nil, fmt.Errorf("failed to persist rotation state: %v", err)
+	}
+
+	crossSigned, err := crossSignNewRoot(oldRoot, newRoot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to cross-sign new root: %v", err)
+	}
+
+	trustBundle := append(append(append([]byte{}, oldRoot.Cert...), newRoot.Cert...), crossSigned...)
+
+	state.Phase = RotationPhaseDistribute
+	state.TrustBundle = trustBundle
+	if err := store.SaveRotationState(state); err != nil {
+		return nil, nil, fmt.Errorf("failed to persist rotation state: %v", err)
+	}
+
+	if err := securityConfig.UpdateRootCA(oldRoot, trustBundle); err != nil {
+		return nil, nil, fmt.Errorf("failed to distribute trust bundle: %v", err)
+	}
+
+	return newRoot, trustBundle, nil
+}
+
+// PromoteRootCA advances a rotation to RotationPhasePromote, switching
+// securityConfig (and, via it, any CA Server sharing it) over to signing new
+// certificates with newRoot, while keeping state's trust bundle installed so
+// nodes that haven't promoted yet can still be validated. Call this only
+// once the trust bundle from RotateRootCA has reached every node in the
+// cluster.
+func PromoteRootCA(securityConfig *SecurityConfig, newRoot *RootCA, store RotationStore, state *RotationState) error {
+	if err := securityConfig.UpdateRootCA(newRoot, state.TrustBundle); err != nil {
+		return fmt.Errorf("failed to promote new root CA: %v", err)
+	}
+
+	state.Phase = RotationPhasePromote
+	return store.SaveRotationState(state)
+}
+
+// RetireRootCA advances a rotation to RotationPhaseRetire, narrowing
+// securityConfig's trust pool back down to the new root alone and dropping
+// the old root certificate from state, so GetRootCACertificate stops serving
+// it. Call this only once every node has the new root as its trust anchor.
+func RetireRootCA(securityConfig *SecurityConfig, store RotationStore, state *RotationState) error {
+	if err := securityConfig.UpdateRootCA(securityConfig.CurrentRootCA(), nil); err != nil {
+		return fmt.Errorf("failed to retire old root CA: %v", err)
+	}
+
+	state.Phase = RotationPhaseRetire
+	state.OldRootCert = nil
+	return store.SaveRotationState(state)
+}
+
+// crossSignNewRoot issues a certificate for newRoot's public key, signed by
+// oldRoot, carrying the same subject and validity as newRoot's own
+// self-signed certificate. A verifier that only trusts oldRoot can use this
+// certificate as an intermediate to build a chain to a leaf signed by
+// newRoot's key.
+func crossSignNewRoot(oldRoot, newRoot *RootCA) ([]byte, error) {
+	oldCert, err := helpers.ParseCertificatePEM(oldRoot.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse old root certificate: %v", err)
+	}
+
+	newCert, err := helpers.ParseCertificatePEM(newRoot.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new root certificate: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               newCert.Subject,
+		NotBefore:             newCert.NotBefore,
+		NotAfter:              newCert.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, oldCert, newCert.PublicKey, oldRoot.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -0,0 +1,189 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/docker/swarm-v2/api"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestRotateRootCACrossSignedBundle(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	oldRoot, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+	managerConfig, err := genManagerSecurityConfig(oldRoot, tempBaseDir)
+	assert.NoError(t, err)
+
+	store := NewMemoryRotationStore()
+	newRoot, trustBundle, err := RotateRootCA(context.Background(), managerConfig, oldRoot, "swarm-test-CA-2", store)
+	assert.NoError(t, err)
+	assert.Equal(t, RotationPhaseDistribute, store.State().Phase)
+
+	// A node that only trusts the old root should still be able to validate
+	// a peer whose certificate was signed by the new root, by treating the
+	// bundle's cross-signed certificate as an intermediate: the new root
+	// itself must NOT be trusted directly here, or this would pass even if
+	// crossSignNewRoot were broken or never called.
+	oldRootPool, err := GetRootCACertPool(oldRoot.Cert)
+	assert.NoError(t, err)
+
+	bundleCerts, err := splitPEMCertificates(trustBundle)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(bundleCerts))
+	crossSigned := bundleCerts[2]
+
+	intermediates := x509.NewCertPool()
+	crossSignedCert, err := helpers.ParseCertificatePEM(crossSigned)
+	assert.NoError(t, err)
+	intermediates.AddCert(crossSignedCert)
+
+	csr, _, err := generateNewCSR()
+	assert.NoError(t, err)
+	peerCertPEM, err := ParseValidateAndSignCSR(newRoot, csr, "peer", "swarm-worker")
+	assert.NoError(t, err)
+
+	peerCert, err := helpers.ParseCertificatePEM(peerCertPEM)
+	assert.NoError(t, err)
+
+	_, err = peerCert.Verify(x509.VerifyOptions{
+		Roots:         oldRootPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	assert.NoError(t, err)
+}
+
+// TestRotateRootCAWidensLiveMTLSTrust exercises the rotation through a real
+// mTLS handshake, by calling GetRootCACertificate over a connection that
+// presents a client certificate, rather than through GetRemoteCA: once
+// RotateRootCA reaches Distribute, a manager's own ServerTLSCreds must
+// already accept a client presenting a certificate signed by the new root,
+// even though the manager itself is still signing (and presenting a
+// certificate signed) with the old one.
+func TestRotateRootCAWidensLiveMTLSTrust(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	oldRoot, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+	managerConfig, err := genManagerSecurityConfig(oldRoot, tempBaseDir)
+	assert.NoError(t, err)
+
+	opts := []grpc.ServerOption{grpc.Creds(managerConfig.ServerTLSCreds)}
+	grpcServer := grpc.NewServer(opts...)
+	caServer := NewServer(managerConfig)
+	api.RegisterCAServer(grpcServer, caServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(l)
+	defer grpcServer.Stop()
+
+	store := NewMemoryRotationStore()
+	newRoot, _, err := RotateRootCA(context.Background(), managerConfig, oldRoot, "swarm-test-CA-2", store)
+	assert.NoError(t, err)
+
+	csr, key, err := generateNewCSR()
+	assert.NoError(t, err)
+	peerCertPEM, err := ParseValidateAndSignCSR(newRoot, csr, "peer", "swarm-worker")
+	assert.NoError(t, err)
+	peerCert, err := tls.X509KeyPair(peerCertPEM, key)
+	assert.NoError(t, err)
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates:       []tls.Certificate{peerCert},
+		InsecureSkipVerify: true,
+	})
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(creds))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	client := api.NewCAClient(conn)
+	_, err = client.GetRootCACertificate(context.Background(), &api.GetRootCACertificateRequest{})
+	assert.NoError(t, err)
+}
+
+func TestGetRemoteCADuringRotation(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	oldRoot, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+	managerConfig, err := genManagerSecurityConfig(oldRoot, tempBaseDir)
+	assert.NoError(t, err)
+
+	opts := []grpc.ServerOption{grpc.Creds(managerConfig.ServerTLSCreds)}
+	grpcServer := grpc.NewServer(opts...)
+	caServer := NewServer(managerConfig)
+	api.RegisterCAServer(grpcServer, caServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(l)
+	defer grpcServer.Stop()
+
+	oldHash := certDigestHex(oldRoot.Cert)
+
+	store := NewMemoryRotationStore()
+	newRoot, _, err := RotateRootCA(context.Background(), managerConfig, oldRoot, "swarm-test-CA-2", store)
+	assert.NoError(t, err)
+	newHash := certDigestHex(newRoot.Cert)
+
+	ctx := context.Background()
+
+	// Both the old and the new digest are accepted while the rotation is in
+	// flight, after Distribute has widened the trust bundle served here.
+	_, err = GetRemoteCA(ctx, l.Addr().String(), oldHash)
+	assert.NoError(t, err)
+	_, err = GetRemoteCA(ctx, l.Addr().String(), newHash)
+	assert.NoError(t, err)
+
+	assert.NoError(t, PromoteRootCA(managerConfig, newRoot, store, store.State()))
+	assert.Equal(t, RotationPhasePromote, store.State().Phase)
+
+	// Promote switches the signer but the bundle is still in place, so both
+	// digests are still accepted.
+	_, err = GetRemoteCA(ctx, l.Addr().String(), oldHash)
+	assert.NoError(t, err)
+	_, err = GetRemoteCA(ctx, l.Addr().String(), newHash)
+	assert.NoError(t, err)
+
+	// A CSR signed now comes from the new root.
+	csr, _, err := generateNewCSR()
+	assert.NoError(t, err)
+	nodeCertPEM, err := ParseValidateAndSignCSR(managerConfig.CurrentRootCA(), csr, "peer", "swarm-worker")
+	assert.NoError(t, err)
+	nodeCert, err := helpers.ParseCertificatePEM(nodeCertPEM)
+	assert.NoError(t, err)
+	newRootPool, err := GetRootCACertPool(newRoot.Cert)
+	assert.NoError(t, err)
+	_, err = nodeCert.Verify(x509.VerifyOptions{Roots: newRootPool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, RetireRootCA(managerConfig, store, store.State()))
+	assert.Equal(t, RotationPhaseRetire, store.State().Phase)
+
+	// Once retired, only the new digest is accepted.
+	_, err = GetRemoteCA(ctx, l.Addr().String(), newHash)
+	assert.NoError(t, err)
+	_, err = GetRemoteCA(ctx, l.Addr().String(), oldHash)
+	assert.Error(t, err)
+}
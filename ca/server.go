@@ -0,0 +1,229 @@
+package ca
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/docker/swarm-v2/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Server implements api.CAServer. It signs node CSRs either by delegating to
+// one of the configured external CAs or, if none are configured or every one
+// of them fails to sign, by falling back to the local root signer. A CSR that
+// an external CA rejects is signed locally just like one whose CA is
+// unreachable; the two failures are not told apart.
+type Server struct {
+	config *SecurityConfig
+
+	externalCA *ExternalCA
+	joinTokens *JoinTokens
+}
+
+// NewServer creates a CA Server that signs certificates using the root
+// signer carried in securityConfig, delegating to any external CAs
+// configured in securityConfig.CAConfig first.
+func NewServer(securityConfig *SecurityConfig) *Server {
+	s := &Server{
+		config:     securityConfig,
+		joinTokens: securityConfig.JoinTokens,
+	}
+
+	if len(securityConfig.CAConfig.ExternalCAs) > 0 {
+		s.externalCA = NewExternalCA(securityConfig.CAConfig.ExternalCAs)
+	}
+
+	return s
+}
+
+// GetRootCACertificate returns the root CA certificate this server trusts.
+// While a rotation is in flight (see UpdateRootCA) this is the trust bundle
+// covering both the old and the new root, rather than the new root alone.
+func (s *Server) GetRootCACertificate(ctx context.Context, req *api.GetRootCACertificateRequest) (*api.GetRootCACertificateResponse, error) {
+	return &api.GetRootCACertificateResponse{
+		Certificate: s.config.TrustBundle(),
+	}, nil
+}
+
+// UpdateRootCA swaps in rootCA as the certificate authority this server's
+// SecurityConfig signs with, validates peers against, and serves.
+// trustBundle, when non-nil, is served from GetRootCACertificate and widens
+// the mTLS trust pool to cover every certificate it contains, so that nodes
+// which still only trust the old root of an in-flight rotation (see
+// RotateRootCA) can keep validating, and be validated by, this one; pass nil
+// once the rotation has been retired.
+func (s *Server) UpdateRootCA(rootCA *RootCA, trustBundle []byte) error {
+	return s.config.UpdateRootCA(rootCA, trustBundle)
+}
+
+// signCSR signs csrPEM with cn/ou, preferring any configured external CA and
+// falling back to the local root signer when none is configured or every
+// external CA returns an error, whether it is unreachable or rejects the CSR.
+func (s *Server) signCSR(csrPEM []byte, cn, ou string) ([]byte, error) {
+	if s.externalCA != nil {
+		cert, err := s.externalCA.Sign(csrPEM, cn, ou)
+		if err == nil {
+			return cert, nil
+		}
+		// Every external CA returned an error (unreachable or rejected):
+		// fall back to the local signer rather than failing the request
+		// outright.
+	}
+
+	rootCA := s.config.CurrentRootCA()
+	if rootCA == nil {
+		return nil, fmt.Errorf("no local signer configured and no external CA available")
+	}
+
+	return ParseValidateAndSignCSR(rootCA, csrPEM, cn, ou)
+}
+
+// RenewCertificate issues a fresh certificate for a node renewing over a
+// connection already authenticated with its current certificate: the new
+// certificate's CN/OU are taken from that peer certificate rather than the
+// CSR, so a node can never renew itself into a different identity.
+func (s *Server) RenewCertificate(ctx context.Context, req *api.RenewCertificateRequest) (*api.RenewCertificateResponse, error) {
+	cn, ou, err := certificateSubjectFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := s.signCSR(req.Csr, cn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RenewCertificateResponse{Certificate: cert}, nil
+}
+
+// IssueNodeCertificate signs a certificate for a brand new node presenting a
+// join token instead of an existing certificate. The token determines the
+// issued certificate's OU (swarm-manager or swarm-worker); the CSR's own
+// requested subject is ignored.
+func (s *Server) IssueNodeCertificate(ctx context.Context, req *api.IssueNodeCertificateRequest) (*api.IssueNodeCertificateResponse, error) {
+	if s.joinTokens == nil {
+		return nil, fmt.Errorf("node enrollment is not configured on this CA")
+	}
+	rootCA := s.config.CurrentRootCA()
+	if rootCA == nil {
+		return nil, fmt.Errorf("no local root CA configured to validate join tokens against")
+	}
+
+	role, err := s.joinTokens.Role(rootCA.Cert, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeID, err := newNodeID()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := s.signCSR(req.Csr, nodeID, string(role))
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.IssueNodeCertificateResponse{Certificate: cert}, nil
+}
+
+// certificateSubjectFromContext extracts the CN/OU of the client certificate
+// that authenticated the current RPC's connection.
+func certificateSubjectFromContext(ctx context.Context) (cn, ou string, err error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", "", fmt.Errorf("no peer information in context")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return "", "", fmt.Errorf("no peer certificate in context")
+	}
+
+	peerCert := tlsInfo.State.PeerCertificates[0]
+	if len(peerCert.Subject.OrganizationalUnit) == 0 {
+		return "", "", fmt.Errorf("peer certificate is missing an organizational unit")
+	}
+
+	return peerCert.Subject.CommonName, peerCert.Subject.OrganizationalUnit[0], nil
+}
+
+// GetRemoteCA returns the root CA certificate (or, during a rotation, the
+// trust bundle) served by the manager at addr, verifying that hash matches
+// the SHA-256 digest of at least one certificate it contains before trusting
+// it. The connection itself doesn't verify the server's certificate: a node
+// bootstrapping into the cluster has no root CA to verify it against yet,
+// which is exactly why the digest check below exists. Accepting a match
+// against any certificate in the response, rather than just the first, is
+// what lets a node pinned to either the old or the new root of an in-flight
+// rotation accept the same response.
+func GetRemoteCA(ctx context.Context, addr, hash string) ([]byte, error) {
+	conn, err := dialUnverifiedTLS(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := api.NewCAClient(conn)
+	resp, err := client.GetRootCACertificate(ctx, &api.GetRootCACertificateRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := splitPEMCertificates(resp.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs {
+		if certDigestHex(cert) == hash {
+			return resp.Certificate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("remote CA certificate does not match expected hash %s", hash)
+}
+
+// certDigestHex returns the hex-encoded SHA-256 digest of cert, used both to
+// pin a remote CA (GetRemoteCA) and to bind join tokens to a particular
+// root CA (JoinTokens).
+func certDigestHex(cert []byte) string {
+	digest := sha256.Sum256(cert)
+	return hex.EncodeToString(digest[:])
+}
+
+// splitPEMCertificates splits a PEM blob containing one or more concatenated
+// certificates (as served by GetRootCACertificate during a rotation) into
+// one PEM-encoded blob per certificate.
+func splitPEMCertificates(bundle []byte) ([][]byte, error) {
+	var certs [][]byte
+	rest := bundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		certs = append(certs, pem.EncodeToMemory(block))
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// dialUnverifiedTLS dials addr over TLS without verifying the server's
+// certificate, for GetRootCACertificate, which a node has to call before it
+// has any basis to trust the manager's certificate. Enrollment through
+// IssueNodeCertificate (GetRemoteSignedCertificate) instead verifies the
+// manager against the root pool obtained that way.
+func dialUnverifiedTLS(addr string) (*grpc.ClientConn, error) {
+	creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	return grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+}
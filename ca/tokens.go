@@ -0,0 +1,152 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Role identifies the kind of node a join token enrolls as, and is carried
+// as the OU of the certificate IssueNodeCertificate returns.
+type Role string
+
+const (
+	// ManagerRole is the OU given to nodes joining with a manager token.
+	ManagerRole Role = "swarm-manager"
+	// WorkerRole is the OU given to nodes joining with a worker token.
+	WorkerRole Role = "swarm-worker"
+
+	joinTokenPrefix  = "SWMTKN"
+	joinTokenVersion = "1"
+
+	joinTokenSecretBytes = 16
+	nodeIDBytes          = 16
+)
+
+// GenerateJoinToken creates a new join token for role, bound to rootCACert
+// via its SHA-256 digest: a node presenting this token can only be accepted
+// by a manager trusting that exact root CA. It returns both the token
+// string handed to operators and the secret backing it, which the caller is
+// responsible for remembering in order to validate the token later.
+func GenerateJoinToken(rootCACert []byte, role Role) (token, secret string, err error) {
+	secret, err = generateTokenSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	token = fmt.Sprintf("%s-%s-%s-%s", joinTokenPrefix, joinTokenVersion, secret, certDigestHex(rootCACert))
+	return token, secret, nil
+}
+
+// ParseJoinToken splits a join token produced by GenerateJoinToken back into
+// its secret and root CA digest.
+func ParseJoinToken(token string) (secret, digest string, err error) {
+	parts := strings.Split(token, "-")
+	if len(parts) != 4 || parts[0] != joinTokenPrefix {
+		return "", "", fmt.Errorf("invalid join token")
+	}
+	if parts[1] != joinTokenVersion {
+		return "", "", fmt.Errorf("unsupported join token version %q", parts[1])
+	}
+
+	return parts[2], parts[3], nil
+}
+
+func generateTokenSecret() (string, error) {
+	b := make([]byte, joinTokenSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newNodeID generates the random identifier used as the CN of a freshly
+// enrolled node's certificate.
+func newNodeID() (string, error) {
+	b := make([]byte, nodeIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// JoinTokens tracks the secrets currently accepted from nodes enrolling as
+// a manager or a worker. Rotating a role's token (UpdateJoinToken)
+// invalidates every token issued for that role before the rotation.
+type JoinTokens struct {
+	mu      sync.RWMutex
+	manager string
+	worker  string
+}
+
+// NewJoinTokens generates an initial manager and worker token bound to
+// rootCACert, returning the JoinTokens that accepts them and the two token
+// strings to hand out to operators.
+func NewJoinTokens(rootCACert []byte) (jt *JoinTokens, managerToken, workerToken string, err error) {
+	jt = &JoinTokens{}
+
+	if managerToken, err = jt.UpdateJoinToken(rootCACert, ManagerRole); err != nil {
+		return nil, "", "", err
+	}
+	if workerToken, err = jt.UpdateJoinToken(rootCACert, WorkerRole); err != nil {
+		return nil, "", "", err
+	}
+
+	return jt, managerToken, workerToken, nil
+}
+
+// UpdateJoinToken rotates the secret backing role, returning the new token
+// string. Any token previously issued for role stops being accepted as soon
+// as this returns.
+func (jt *JoinTokens) UpdateJoinToken(rootCACert []byte, role Role) (string, error) {
+	token, secret, err := GenerateJoinToken(rootCACert, role)
+	if err != nil {
+		return "", err
+	}
+
+	jt.mu.Lock()
+	switch role {
+	case ManagerRole:
+		jt.manager = secret
+	case WorkerRole:
+		jt.worker = secret
+	default:
+		jt.mu.Unlock()
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+	jt.mu.Unlock()
+
+	return token, nil
+}
+
+// Role validates token against rootCACert and the secrets currently accepted
+// for each role, returning the role it was issued for.
+func (jt *JoinTokens) Role(rootCACert []byte, token string) (Role, error) {
+	secret, digest, err := ParseJoinToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	if digest != certDigestHex(rootCACert) {
+		return "", fmt.Errorf("join token does not match this cluster's root CA")
+	}
+
+	jt.mu.RLock()
+	defer jt.mu.RUnlock()
+
+	if jt.manager != "" && secretsEqual(secret, jt.manager) {
+		return ManagerRole, nil
+	}
+	if jt.worker != "" && secretsEqual(secret, jt.worker) {
+		return WorkerRole, nil
+	}
+
+	return "", fmt.Errorf("invalid or expired join token")
+}
+
+func secretsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
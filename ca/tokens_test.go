@@ -0,0 +1,81 @@
+package ca
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/docker/swarm-v2/api"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestIssueNodeCertificate(t *testing.T) {
+	tempBaseDir, err := ioutil.TempDir("", "swarm-ca-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempBaseDir)
+
+	paths := NewConfigPaths(tempBaseDir)
+
+	rootCA, err := CreateRootCA(paths.RootCACert, paths.RootCAKey, "swarm-test-CA")
+	assert.NoError(t, err)
+
+	managerConfig, err := genManagerSecurityConfig(rootCA, tempBaseDir)
+	assert.NoError(t, err)
+
+	joinTokens, managerToken, workerToken, err := NewJoinTokens(rootCA.Cert)
+	assert.NoError(t, err)
+	managerConfig.JoinTokens = joinTokens
+
+	opts := []grpc.ServerOption{grpc.Creds(managerConfig.ServerTLSCreds)}
+	grpcServer := grpc.NewServer(opts...)
+	caServer := NewServer(managerConfig)
+	api.RegisterCAServer(grpcServer, caServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go grpcServer.Serve(l)
+	defer grpcServer.Stop()
+
+	conn, err := dialUnverifiedTLS(l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := api.NewCAClient(conn)
+
+	csr, _, err := generateNewCSR()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	managerResp, err := client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{Csr: csr, Token: managerToken})
+	assert.NoError(t, err)
+	managerCert, err := helpers.ParseCertificatesPEM(managerResp.Certificate)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(managerCert[0].Subject.OrganizationalUnit))
+	assert.Equal(t, string(ManagerRole), managerCert[0].Subject.OrganizationalUnit[0])
+
+	workerResp, err := client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{Csr: csr, Token: workerToken})
+	assert.NoError(t, err)
+	workerCert, err := helpers.ParseCertificatesPEM(workerResp.Certificate)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(workerCert[0].Subject.OrganizationalUnit))
+	assert.Equal(t, string(WorkerRole), workerCert[0].Subject.OrganizationalUnit[0])
+
+	_, err = client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{
+		Csr:   csr,
+		Token: "SWMTKN-1-deadbeefdeadbeefdeadbeefdeadbeef-0000000000000000000000000000000000000000000000000000000000000000",
+	})
+	assert.Error(t, err)
+
+	rotatedManagerToken, err := joinTokens.UpdateJoinToken(rootCA.Cert, ManagerRole)
+	assert.NoError(t, err)
+	assert.NotEqual(t, managerToken, rotatedManagerToken)
+
+	_, err = client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{Csr: csr, Token: managerToken})
+	assert.Error(t, err)
+
+	_, err = client.IssueNodeCertificate(ctx, &api.IssueNodeCertificateRequest{Csr: csr, Token: rotatedManagerToken})
+	assert.NoError(t, err)
+}